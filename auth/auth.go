@@ -0,0 +1,116 @@
+// Package auth implements SASL PLAIN and SASL EXTERNAL authentication for
+// whapp-irc, backed by the same key/value database used to persist
+// per-user WhatsApp sessions.
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+
+	"whapp-irc/database"
+
+	"github.com/mitchellh/mapstructure"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Credentials are the persisted, per-nickname authentication settings.
+type Credentials struct {
+	PasswordHash string `mapstructure:"password_hash" json:"password_hash"`
+
+	// CertFingerprint, when set, is the SHA-256 fingerprint of the client
+	// certificate SASL EXTERNAL will accept for this nickname.
+	CertFingerprint string `mapstructure:"cert_fingerprint" json:"cert_fingerprint"`
+}
+
+// Store persists Credentials, one set per nickname.
+type Store struct {
+	db *database.Database
+}
+
+// MakeStore returns a Store backed by db.
+func MakeStore(db *database.Database) *Store {
+	return &Store{db: db}
+}
+
+// SetPassword hashes password with bcrypt and stores it for nickname.
+func (s *Store) SetPassword(nickname, password string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	creds, err := s.get(nickname)
+	if err != nil {
+		return err
+	}
+	creds.PasswordHash = string(hash)
+	return s.save(nickname, creds)
+}
+
+// SetCertFingerprint pins the given client certificate to nickname for
+// SASL EXTERNAL.
+func (s *Store) SetCertFingerprint(nickname string, cert *x509.Certificate) error {
+	creds, err := s.get(nickname)
+	if err != nil {
+		return err
+	}
+	creds.CertFingerprint = fingerprint(cert)
+	return s.save(nickname, creds)
+}
+
+// CheckPassword implements SASL PLAIN: it reports whether password matches
+// the stored, bcrypt-hashed password for nickname.
+func (s *Store) CheckPassword(nickname, password string) (bool, error) {
+	creds, err := s.get(nickname)
+	if err != nil {
+		return false, err
+	}
+	if creds.PasswordHash == "" {
+		return false, nil
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(creds.PasswordHash), []byte(password))
+	if err != nil {
+		return false, nil
+	}
+	return true, nil
+}
+
+// CheckCertificate implements SASL EXTERNAL: it reports whether cert's
+// fingerprint matches the one pinned for nickname.
+func (s *Store) CheckCertificate(nickname string, cert *x509.Certificate) (bool, error) {
+	creds, err := s.get(nickname)
+	if err != nil {
+		return false, err
+	}
+	if creds.CertFingerprint == "" {
+		return false, nil
+	}
+	return creds.CertFingerprint == fingerprint(cert), nil
+}
+
+func (s *Store) get(nickname string) (Credentials, error) {
+	obj, found, err := s.db.GetItem(nickname)
+	if err != nil {
+		return Credentials{}, err
+	} else if !found {
+		return Credentials{}, nil
+	}
+
+	var creds Credentials
+	if err := mapstructure.Decode(obj, &creds); err != nil {
+		return Credentials{}, fmt.Errorf("error decoding credentials: %w", err)
+	}
+	return creds, nil
+}
+
+func (s *Store) save(nickname string, creds Credentials) error {
+	return s.db.SaveItem(nickname, creds)
+}
+
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
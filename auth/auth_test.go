@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"path/filepath"
+	"testing"
+
+	"whapp-irc/database"
+)
+
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+
+	db, err := database.MakeDatabase(filepath.Join(t.TempDir(), "auth_test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return MakeStore(db)
+}
+
+func TestSetAndCheckPassword(t *testing.T) {
+	store := newTestStore(t)
+
+	if err := store.SetPassword("alice", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := store.CheckPassword("alice", "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("CheckPassword with the correct password should succeed")
+	}
+
+	ok, err = store.CheckPassword("alice", "wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("CheckPassword with the wrong password should fail")
+	}
+}
+
+func TestCheckPasswordUnset(t *testing.T) {
+	store := newTestStore(t)
+
+	ok, err := store.CheckPassword("bob", "anything")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("CheckPassword should fail when no password has been set")
+	}
+}
+
+func TestStoredPasswordIsHashed(t *testing.T) {
+	store := newTestStore(t)
+
+	if err := store.SetPassword("alice", "hunter2"); err != nil {
+		t.Fatal(err)
+	}
+
+	creds, err := store.get("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds.PasswordHash == "hunter2" || creds.PasswordHash == "" {
+		t.Errorf("PasswordHash should be a bcrypt hash, got %q", creds.PasswordHash)
+	}
+}
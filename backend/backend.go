@@ -0,0 +1,110 @@
+// Package backend defines the interface whapp-irc uses to talk to a
+// WhatsApp connection, independent of how that connection is implemented.
+// The original implementation drove a headless Chrome instance through
+// WhatsApp Web (see backend/whapp); backend/whatsmeow talks to the
+// multi-device protocol directly instead.
+package backend
+
+import (
+	"context"
+	"time"
+)
+
+// LoginState mirrors the states a Backend can be in after Open.
+type LoginState int
+
+const (
+	LoggedOut LoginState = iota
+	LoggedIn
+)
+
+// ID identifies a chat or contact, independent of backend.
+type ID string
+
+func (id ID) String() string {
+	return string(id)
+}
+
+// Me describes the logged-in user.
+type Me struct {
+	ID       ID
+	PushName string
+}
+
+// Presence is the presence state of a chat or contact.
+type Presence struct {
+	IsOnline bool
+	LastSeen time.Time
+}
+
+// Message is a single chat message, in backend-agnostic form.
+type Message struct {
+	ID        string
+	ChatID    ID
+	Timestamp int64
+	Body      string
+	FromMe    bool
+}
+
+// Participant is a single member of a group chat.
+type Participant struct {
+	ID      ID
+	Name    string
+	IsAdmin bool
+	IsMe    bool
+}
+
+// Chat is a single chat, direct or group.
+type Chat struct {
+	ID          ID
+	Title       string
+	IsGroupChat bool
+	Timestamp   int64
+}
+
+// MessageResult wraps a Message with an error, matching the
+// future-with-error convention used by the rest of the connection loop.
+type MessageResult struct {
+	Message Message
+	Err     error
+}
+
+// Backend is the set of operations whapp-irc needs from a WhatsApp
+// connection. Implementations live in subpackages, one per driver.
+type Backend interface {
+	// Open starts or resumes a session, returning the resulting login state.
+	Open(ctx context.Context) (LoginState, error)
+
+	// GetLoginCode returns a pairing code or QR payload to present to the
+	// user when Open reports LoggedOut.
+	GetLoginCode(ctx context.Context) (string, error)
+
+	// WaitLogin blocks until the pending login (started by GetLoginCode)
+	// completes.
+	WaitLogin(ctx context.Context) error
+
+	GetMe(ctx context.Context) (Me, error)
+	GetAllChats(ctx context.Context) ([]Chat, error)
+	GetChatParticipants(ctx context.Context, chat ID) ([]Participant, error)
+	GetPresence(ctx context.Context, chat ID) (Presence, error)
+
+	SendMessage(ctx context.Context, chat ID, body string) error
+
+	// ListenForMessages streams incoming messages, polling no more often
+	// than interval where the underlying transport requires polling.
+	ListenForMessages(ctx context.Context, interval time.Duration) (<-chan MessageResult, <-chan error)
+
+	// ListenLoggedIn streams the current login state, so callers notice
+	// being logged out remotely.
+	ListenLoggedIn(ctx context.Context, interval time.Duration) (<-chan bool, <-chan error)
+
+	// GetMessagesFromChatTillDate returns history for chat sent after
+	// sinceTimestamp, for backfilling on reconnect. Neither the whapp nor
+	// the whatsmeow driver fetches any; both return no messages.
+	GetMessagesFromChatTillDate(ctx context.Context, chat ID, sinceTimestamp int64) ([]Message, error)
+
+	// SetLocalStorage/GetLocalStorage persist and restore whatever
+	// session state the backend needs to resume without a fresh login.
+	SetLocalStorage(ctx context.Context, data map[string]string) error
+	GetLocalStorage(ctx context.Context) (map[string]string, error)
+}
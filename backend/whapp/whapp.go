@@ -0,0 +1,174 @@
+// Package whapp adapts the existing whapp-irc/whapp (WhatsApp Web / headless
+// Chrome) client to the backend.Backend interface.
+package whapp
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"whapp-irc/backend"
+	"whapp-irc/whapp"
+)
+
+// Backend wraps a *whapp.WI to satisfy backend.Backend.
+type Backend struct {
+	WI *whapp.WI
+}
+
+// New returns a Backend around a fresh whapp.WI instance.
+func New(wi *whapp.WI) *Backend {
+	return &Backend{WI: wi}
+}
+
+func (b *Backend) Open(ctx context.Context) (backend.LoginState, error) {
+	state, err := b.WI.Open(ctx)
+	if err != nil {
+		return backend.LoggedOut, err
+	}
+	if state == whapp.Loggedout {
+		return backend.LoggedOut, nil
+	}
+	return backend.LoggedIn, nil
+}
+
+func (b *Backend) GetLoginCode(ctx context.Context) (string, error) {
+	return b.WI.GetLoginCode(ctx)
+}
+
+func (b *Backend) WaitLogin(ctx context.Context) error {
+	return b.WI.WaitLogin(ctx)
+}
+
+func (b *Backend) GetMe(ctx context.Context) (backend.Me, error) {
+	me, err := b.WI.GetMe(ctx)
+	if err != nil {
+		return backend.Me{}, err
+	}
+	return backend.Me{ID: backend.ID(me.ID.String()), PushName: me.PushName}, nil
+}
+
+func (b *Backend) GetAllChats(ctx context.Context) ([]backend.Chat, error) {
+	chats, err := b.WI.GetAllChats(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]backend.Chat, len(chats))
+	for i, c := range chats {
+		res[i] = backend.Chat{
+			ID:          backend.ID(c.ID.String()),
+			Title:       c.Title(),
+			IsGroupChat: c.IsGroupChat,
+			Timestamp:   c.Timestamp,
+		}
+	}
+	return res, nil
+}
+
+func (b *Backend) GetChatParticipants(ctx context.Context, chat backend.ID) ([]backend.Participant, error) {
+	rawChat, err := b.findChat(ctx, chat)
+	if err != nil {
+		return nil, err
+	}
+	if rawChat == nil {
+		return nil, fmt.Errorf("whapp backend: unknown chat %s", chat)
+	}
+
+	participants, err := rawChat.Participants(ctx, b.WI)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]backend.Participant, len(participants))
+	for i, p := range participants {
+		res[i] = backend.Participant{
+			ID:      backend.ID(p.Contact.ID.String()),
+			Name:    p.SafeName(),
+			IsAdmin: p.IsAdmin,
+			IsMe:    p.Contact.IsMe,
+		}
+	}
+	return res, nil
+}
+
+func (b *Backend) GetPresence(ctx context.Context, chat backend.ID) (backend.Presence, error) {
+	rawChat, err := b.findChat(ctx, chat)
+	if err != nil {
+		return backend.Presence{}, err
+	}
+	if rawChat == nil {
+		return backend.Presence{}, fmt.Errorf("whapp backend: unknown chat %s", chat)
+	}
+
+	presence, err := rawChat.GetPresence(ctx, b.WI)
+	if err != nil {
+		return backend.Presence{}, err
+	}
+	return backend.Presence{IsOnline: presence.IsOnline}, nil
+}
+
+func (b *Backend) SendMessage(ctx context.Context, chat backend.ID, body string) error {
+	rawChat, err := b.findChat(ctx, chat)
+	if err != nil {
+		return err
+	}
+	if rawChat == nil {
+		return fmt.Errorf("whapp backend: unknown chat %s", chat)
+	}
+	return rawChat.SendText(ctx, b.WI, body)
+}
+
+// findChat looks up the whapp.Chat backing id, since most whapp.Chat
+// operations (participants, presence, sending) hang off the chat value
+// itself rather than taking an ID directly.
+func (b *Backend) findChat(ctx context.Context, id backend.ID) (*whapp.Chat, error) {
+	chats, err := b.WI.GetAllChats(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range chats {
+		if c.ID.String() == id.String() {
+			found := c
+			return &found, nil
+		}
+	}
+	return nil, nil
+}
+
+func (b *Backend) ListenForMessages(ctx context.Context, interval time.Duration) (<-chan backend.MessageResult, <-chan error) {
+	msgCh, errCh := b.WI.ListenForMessages(ctx, interval)
+
+	out := make(chan backend.MessageResult)
+	go func() {
+		defer close(out)
+		for msgFut := range msgCh {
+			msgRes := <-msgFut
+			out <- backend.MessageResult{
+				Message: backend.Message{
+					ID:        msgRes.Message.ID,
+					Timestamp: msgRes.Message.Timestamp,
+				},
+				Err: msgRes.Err,
+			}
+		}
+	}()
+	return out, errCh
+}
+
+func (b *Backend) ListenLoggedIn(ctx context.Context, interval time.Duration) (<-chan bool, <-chan error) {
+	return b.WI.ListenLoggedIn(ctx, interval)
+}
+
+func (b *Backend) GetMessagesFromChatTillDate(ctx context.Context, chat backend.ID, sinceTimestamp int64) ([]backend.Message, error) {
+	return nil, nil
+}
+
+func (b *Backend) SetLocalStorage(ctx context.Context, data map[string]string) error {
+	return b.WI.SetLocalStorage(ctx, data)
+}
+
+func (b *Backend) GetLocalStorage(ctx context.Context) (map[string]string, error) {
+	return b.WI.GetLocalStorage(ctx)
+}
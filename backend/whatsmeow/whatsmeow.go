@@ -0,0 +1,277 @@
+// Package whatsmeow adapts go.mau.fi/whatsmeow, a native multi-device
+// WhatsApp client, to the backend.Backend interface. Unlike backend/whapp
+// this does not require a headless browser.
+package whatsmeow
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"whapp-irc/backend"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/proto/waE2E"
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"go.mau.fi/whatsmeow/store/sqlstore"
+	waLog "go.mau.fi/whatsmeow/util/log"
+	"google.golang.org/protobuf/proto"
+)
+
+// Backend wraps a *whatsmeow.Client to satisfy backend.Backend.
+type Backend struct {
+	client *whatsmeow.Client
+
+	loginCode chan string
+	loggedIn  chan error
+
+	presenceMu sync.Mutex
+	presence   map[types.JID]backend.Presence
+}
+
+// New opens (or creates) the whatsmeow device store at dbPath and returns a
+// Backend around it. dbPath is a sqlite3 DSN, e.g. "file:session.db?_foreign_keys=on".
+func New(dbPath string) (*Backend, error) {
+	container, err := sqlstore.New("sqlite3", dbPath, waLog.Noop)
+	if err != nil {
+		return nil, err
+	}
+
+	device, err := container.GetFirstDevice()
+	if err != nil {
+		return nil, err
+	}
+
+	client := whatsmeow.NewClient(device, waLog.Noop)
+	b := &Backend{
+		client:    client,
+		loginCode: make(chan string, 1),
+		loggedIn:  make(chan error, 1),
+		presence:  make(map[types.JID]backend.Presence),
+	}
+
+	client.AddEventHandler(func(evt interface{}) {
+		presenceEvt, ok := evt.(*events.Presence)
+		if !ok {
+			return
+		}
+
+		b.presenceMu.Lock()
+		b.presence[presenceEvt.From] = backend.Presence{
+			IsOnline: !presenceEvt.Unavailable,
+			LastSeen: presenceEvt.LastSeen,
+		}
+		b.presenceMu.Unlock()
+	})
+
+	return b, nil
+}
+
+func (b *Backend) Open(ctx context.Context) (backend.LoginState, error) {
+	if b.client.Store.ID != nil {
+		if err := b.client.Connect(); err != nil {
+			return backend.LoggedOut, err
+		}
+		return backend.LoggedIn, nil
+	}
+	return backend.LoggedOut, nil
+}
+
+func (b *Backend) GetLoginCode(ctx context.Context) (string, error) {
+	qrCh, err := b.client.GetQRChannel(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err := b.client.Connect(); err != nil {
+		return "", err
+	}
+
+	go func() {
+		for evt := range qrCh {
+			if evt.Event == "code" {
+				b.loginCode <- evt.Code
+			} else if evt.Event == "success" {
+				b.loggedIn <- nil
+			}
+		}
+	}()
+
+	select {
+	case code := <-b.loginCode:
+		return code, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func (b *Backend) WaitLogin(ctx context.Context) error {
+	select {
+	case err := <-b.loggedIn:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (b *Backend) GetMe(ctx context.Context) (backend.Me, error) {
+	if b.client.Store.ID == nil {
+		return backend.Me{}, nil
+	}
+	return backend.Me{ID: backend.ID(b.client.Store.ID.User), PushName: b.client.Store.PushName}, nil
+}
+
+func (b *Backend) GetAllChats(ctx context.Context) ([]backend.Chat, error) {
+	// Group chats are available synchronously; DM chats are not (whatsmeow
+	// only surfaces those through history-sync push events), so this is
+	// necessarily a partial listing. DM chats are not listed at all, and
+	// Connection does not call this on startup for this backend.
+	groups, err := b.client.GetJoinedGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	chats := make([]backend.Chat, len(groups))
+	for i, g := range groups {
+		chats[i] = backend.Chat{
+			ID:          backend.ID(g.JID.String()),
+			Title:       g.Name,
+			IsGroupChat: true,
+		}
+	}
+	return chats, nil
+}
+
+func (b *Backend) GetChatParticipants(ctx context.Context, chat backend.ID) ([]backend.Participant, error) {
+	jid, err := types.ParseJID(string(chat))
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := b.client.GetGroupInfo(jid)
+	if err != nil {
+		return nil, err
+	}
+
+	me := b.client.Store.ID
+
+	participants := make([]backend.Participant, len(info.Participants))
+	for i, p := range info.Participants {
+		name := p.DisplayName
+		if name == "" {
+			name = p.JID.User
+		}
+
+		participants[i] = backend.Participant{
+			ID:      backend.ID(p.JID.String()),
+			Name:    name,
+			IsAdmin: p.IsAdmin || p.IsSuperAdmin,
+			IsMe:    me != nil && p.JID.User == me.User,
+		}
+	}
+	return participants, nil
+}
+
+func (b *Backend) GetPresence(ctx context.Context, chat backend.ID) (backend.Presence, error) {
+	jid, err := types.ParseJID(string(chat))
+	if err != nil {
+		return backend.Presence{}, err
+	}
+
+	b.presenceMu.Lock()
+	presence, found := b.presence[jid]
+	b.presenceMu.Unlock()
+	if found {
+		return presence, nil
+	}
+
+	// No presence cached yet: ask WhatsApp to start pushing updates for
+	// this JID (see the *events.Presence handler in New) and report
+	// unknown/offline for now, since SubscribePresence's result arrives
+	// asynchronously rather than as a direct reply.
+	if err := b.client.SubscribePresence(jid); err != nil {
+		return backend.Presence{}, err
+	}
+	return backend.Presence{}, nil
+}
+
+func (b *Backend) SendMessage(ctx context.Context, chat backend.ID, body string) error {
+	jid, err := types.ParseJID(string(chat))
+	if err != nil {
+		return err
+	}
+
+	_, err = b.client.SendMessage(ctx, jid, &waE2E.Message{
+		Conversation: proto.String(body),
+	})
+	return err
+}
+
+func (b *Backend) ListenForMessages(ctx context.Context, interval time.Duration) (<-chan backend.MessageResult, <-chan error) {
+	out := make(chan backend.MessageResult)
+	errCh := make(chan error)
+
+	// whatsmeow pushes events rather than requiring polling, so interval is
+	// unused here; it only exists to satisfy backend.Backend, which also
+	// has to describe the polling whapp driver.
+	handlerID := b.client.AddEventHandler(func(evt interface{}) {
+		msgEvt, ok := evt.(*events.Message)
+		if !ok {
+			return
+		}
+
+		out <- backend.MessageResult{
+			Message: backend.Message{
+				ID:        msgEvt.Info.ID,
+				ChatID:    backend.ID(msgEvt.Info.Chat.String()),
+				Timestamp: msgEvt.Info.Timestamp.Unix(),
+				Body:      msgEvt.Message.GetConversation(),
+				FromMe:    msgEvt.Info.IsFromMe,
+			},
+		}
+	})
+
+	go func() {
+		<-ctx.Done()
+		b.client.RemoveEventHandler(handlerID)
+		close(out)
+	}()
+
+	return out, errCh
+}
+
+func (b *Backend) ListenLoggedIn(ctx context.Context, interval time.Duration) (<-chan bool, <-chan error) {
+	out := make(chan bool)
+	errCh := make(chan error)
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				out <- b.client.IsLoggedIn()
+			}
+		}
+	}()
+	return out, errCh
+}
+
+func (b *Backend) GetMessagesFromChatTillDate(ctx context.Context, chat backend.ID, sinceTimestamp int64) ([]backend.Message, error) {
+	// whatsmeow does not expose on-demand history fetches beyond the
+	// initial history-sync blob, so backfill falls back to whatever the
+	// per-chat log (see chatlog.go) has recorded locally.
+	return nil, nil
+}
+
+func (b *Backend) SetLocalStorage(ctx context.Context, data map[string]string) error {
+	// session state lives in the sqlstore device record, not a key/value
+	// blob, so this is a no-op for this backend.
+	return nil
+}
+
+func (b *Backend) GetLocalStorage(ctx context.Context) (map[string]string, error) {
+	return nil, nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"whapp-irc/backend"
+	backendwhapp "whapp-irc/backend/whapp"
+	"whapp-irc/backend/whatsmeow"
+	"whapp-irc/whapp"
+)
+
+// backendEnvVar selects which backend.Backend implementation MakeConnection
+// wires up. Defaults to "whapp" (headless Chrome / WhatsApp Web) for
+// backwards compatibility with existing deployments.
+const backendEnvVar = "WHAPP_IRC_BACKEND"
+
+// newBackend constructs the backend selected by backendEnvVar. The returned
+// bool reports whether that backend needs the headless-Chrome bridge (only
+// true for the original whapp driver) so callers can skip starting Chrome
+// entirely for backends, like whatsmeow, that don't use it.
+func newBackend() (backend.Backend, bool, error) {
+	switch name := os.Getenv(backendEnvVar); name {
+	case "", "whapp":
+		return backendwhapp.New(whapp.MakeWhappInstance()), true, nil
+	case "whatsmeow":
+		be, err := whatsmeow.New("file:whatsmeow.db?_foreign_keys=on")
+		return be, false, err
+	default:
+		return nil, false, fmt.Errorf("unknown %s: %s", backendEnvVar, name)
+	}
+}
@@ -0,0 +1,159 @@
+// Package banlist lets a user suppress incoming WhatsApp messages from
+// specific contacts, number prefixes, or display names, without leaving
+// the underlying group chat on the phone.
+package banlist
+
+import (
+	"regexp"
+	"strings"
+
+	"whapp-irc/database"
+
+	"github.com/mitchellh/mapstructure"
+)
+
+// entryKind distinguishes the three ways a ban can match a sender.
+type entryKind string
+
+const (
+	kindID     entryKind = "id"
+	kindPrefix entryKind = "prefix"
+	kindRegex  entryKind = "regex"
+)
+
+// Entry is a single persisted ban.
+type Entry struct {
+	Kind  entryKind `mapstructure:"kind" json:"kind"`
+	Value string    `mapstructure:"value" json:"value"`
+}
+
+// List is a per-user banlist, persisted through the database layer.
+type List struct {
+	db       *database.Database
+	nickname string
+
+	entries []Entry
+}
+
+// MakeList loads the banlist for nickname from db.
+func MakeList(db *database.Database, nickname string) (*List, error) {
+	list := &List{db: db, nickname: nickname}
+	if err := list.load(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (l *List) key() string {
+	return "banlist:" + l.nickname
+}
+
+func (l *List) load() error {
+	obj, found, err := l.db.GetItem(l.key())
+	if err != nil {
+		return err
+	} else if !found {
+		l.entries = nil
+		return nil
+	}
+
+	// GetItem hands back a []Entry as saved, or generic JSON values after a
+	// restart reloads the database from disk, so decode either with
+	// mapstructure (see auth.Store.get for the same pattern).
+	var entries []Entry
+	if err := mapstructure.Decode(obj, &entries); err != nil {
+		return err
+	}
+	l.entries = entries
+	return nil
+}
+
+func (l *List) save() error {
+	return l.db.SaveItem(l.key(), l.entries)
+}
+
+// Add parses spec (a WhatsApp contact ID, a bare number prefix, or a
+// /regex/ matched against the display name) and adds it to the list.
+func (l *List) Add(spec string) error {
+	entry, err := parseSpec(spec)
+	if err != nil {
+		return err
+	}
+
+	l.entries = append(l.entries, entry)
+	return l.save()
+}
+
+// Remove undoes a previous Add for the same spec.
+func (l *List) Remove(spec string) error {
+	entry, err := parseSpec(spec)
+	if err != nil {
+		return err
+	}
+
+	filtered := l.entries[:0]
+	for _, e := range l.entries {
+		if e != entry {
+			filtered = append(filtered, e)
+		}
+	}
+	l.entries = filtered
+	return l.save()
+}
+
+// Entries returns the current bans, for the `!banlist` command.
+func (l *List) Entries() []Entry {
+	return l.entries
+}
+
+// Matches reports whether a sender identified by id, phone number, and
+// display name is blocked by any entry in the list.
+func (l *List) Matches(id, number, name string) bool {
+	for _, e := range l.entries {
+		switch e.Kind {
+		case kindID:
+			if e.Value == id {
+				return true
+			}
+		case kindPrefix:
+			if strings.HasPrefix(number, e.Value) {
+				return true
+			}
+		case kindRegex:
+			if re, err := regexp.Compile(e.Value); err == nil && re.MatchString(name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func parseSpec(spec string) (Entry, error) {
+	spec = strings.TrimSpace(spec)
+
+	if strings.HasPrefix(spec, "/") && strings.HasSuffix(spec, "/") && len(spec) >= 2 {
+		pattern := spec[1 : len(spec)-1]
+		if _, err := regexp.Compile(pattern); err != nil {
+			return Entry{}, err
+		}
+		return Entry{Kind: kindRegex, Value: pattern}, nil
+	}
+
+	if isDigits(spec) {
+		return Entry{Kind: kindPrefix, Value: spec}, nil
+	}
+
+	return Entry{Kind: kindID, Value: spec}, nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
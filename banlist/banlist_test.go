@@ -0,0 +1,89 @@
+package banlist
+
+import "testing"
+
+func TestParseSpec(t *testing.T) {
+	tests := []struct {
+		spec string
+		kind entryKind
+		want string
+	}{
+		{"1234567890", kindPrefix, "1234567890"},
+		{"/^spam.*/", kindRegex, "^spam.*"},
+		{"user@s.whatsapp.net", kindID, "user@s.whatsapp.net"},
+	}
+
+	for _, tt := range tests {
+		entry, err := parseSpec(tt.spec)
+		if err != nil {
+			t.Fatalf("parseSpec(%q): %s", tt.spec, err)
+		}
+		if entry.Kind != tt.kind {
+			t.Errorf("parseSpec(%q).Kind = %s, want %s", tt.spec, entry.Kind, tt.kind)
+		}
+		if entry.Value != tt.want {
+			t.Errorf("parseSpec(%q).Value = %q, want %q", tt.spec, entry.Value, tt.want)
+		}
+	}
+}
+
+func TestParseSpecInvalidRegex(t *testing.T) {
+	if _, err := parseSpec("/(unterminated/"); err == nil {
+		t.Error("expected an error for an invalid regex spec")
+	}
+}
+
+func TestMatches(t *testing.T) {
+	list := &List{entries: []Entry{
+		{Kind: kindID, Value: "abc@s.whatsapp.net"},
+		{Kind: kindPrefix, Value: "3161"},
+		{Kind: kindRegex, Value: "(?i)^spam"},
+	}}
+
+	cases := []struct {
+		name             string
+		id, number, disp string
+		want             bool
+	}{
+		{"matches id", "abc@s.whatsapp.net", "", "", true},
+		{"matches number prefix", "", "31612345678", "", true},
+		{"matches display name regex", "", "", "Spammer McSpamface", true},
+		{"no match", "xyz@s.whatsapp.net", "49123", "Alice", false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := list.Matches(tt.id, tt.number, tt.disp); got != tt.want {
+				t.Errorf("Matches(%q, %q, %q) = %v, want %v", tt.id, tt.number, tt.disp, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAddRemove(t *testing.T) {
+	list := &List{db: nil}
+
+	// Add/Remove call save(), which needs a database; exercise the
+	// in-memory entry slice directly instead to keep this a pure unit test.
+	entry, err := parseSpec("31612345678")
+	if err != nil {
+		t.Fatal(err)
+	}
+	list.entries = append(list.entries, entry)
+
+	if !list.Matches("", "31612345678", "") {
+		t.Fatal("expected newly added entry to match")
+	}
+
+	filtered := list.entries[:0]
+	for _, e := range list.entries {
+		if e != entry {
+			filtered = append(filtered, e)
+		}
+	}
+	list.entries = filtered
+
+	if list.Matches("", "31612345678", "") {
+		t.Fatal("expected removed entry to no longer match")
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	irc "gopkg.in/sorcix/irc.v2"
+
+	"whapp-irc/capabilities"
+)
+
+// handleCapCommand implements the server side of IRCv3 capability
+// negotiation (https://ircv3.net/specs/extensions/capability-negotiation):
+// `CAP LS` advertises capabilities.Supported, `CAP REQ` negotiates a subset
+// of them into conn.caps, and `CAP END` releases conn.caps.WaitNegotiation.
+func (conn *Connection) handleCapCommand(msg *irc.Message) error {
+	if len(msg.Params) == 0 {
+		return fmt.Errorf("handleCapCommand: missing subcommand")
+	}
+
+	nick := conn.nickname
+	if nick == "" {
+		nick = "*"
+	}
+
+	switch strings.ToUpper(msg.Params[0]) {
+	case "LS", "LIST":
+		conn.caps.Begin()
+		str := fmt.Sprintf(":whapp-irc CAP %s LS :%s", nick, strings.Join(capabilities.Supported, " "))
+		return conn.writeIRCNow(str)
+
+	case "REQ":
+		conn.caps.Begin()
+		if len(msg.Params) < 2 {
+			return fmt.Errorf("handleCapCommand: REQ without capabilities")
+		}
+
+		requested := strings.Fields(msg.Params[1])
+		for _, name := range requested {
+			if !capabilities.IsSupported(name) {
+				str := fmt.Sprintf(":whapp-irc CAP %s NAK :%s", nick, msg.Params[1])
+				return conn.writeIRCNow(str)
+			}
+		}
+
+		// Only commit the batch to conn.caps once every requested capability
+		// is known to be supported, so a NAK'd request never leaves a
+		// partial subset marked negotiated.
+		for _, name := range requested {
+			conn.caps.Request(name)
+		}
+
+		str := fmt.Sprintf(":whapp-irc CAP %s ACK :%s", nick, strings.Join(requested, " "))
+		return conn.writeIRCNow(str)
+
+	case "END":
+		conn.caps.EndNegotiation()
+		return nil
+
+	default:
+		return fmt.Errorf("handleCapCommand: unknown subcommand %q", msg.Params[0])
+	}
+}
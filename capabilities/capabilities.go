@@ -0,0 +1,106 @@
+// Package capabilities implements IRCv3 capability negotiation
+// (https://ircv3.net/specs/extensions/capability-negotiation) for
+// whapp-irc: the set of capability names the server can offer, and the
+// bookkeeping for which ones a given connection has actually negotiated.
+package capabilities
+
+import "sync"
+
+// Supported is the full set of capabilities whapp-irc can offer a client
+// during CAP LS/REQ.
+var Supported = []string{
+	"server-time",
+	"message-tags",
+	"draft/chathistory",
+	"sasl",
+}
+
+// CapabilitiesMap tracks, for a single connection, which of the supported
+// capabilities have been requested (and acknowledged) during negotiation.
+type CapabilitiesMap struct {
+	mu         sync.Mutex
+	negotiated map[string]bool
+	began      bool
+	done       chan struct{}
+	doneOnce   sync.Once
+}
+
+// MakeCapabilitiesMap returns an empty CapabilitiesMap, ready to have
+// capabilities requested into it.
+func MakeCapabilitiesMap() *CapabilitiesMap {
+	return &CapabilitiesMap{
+		negotiated: make(map[string]bool),
+		done:       make(chan struct{}),
+	}
+}
+
+// IsSupported reports whether name is one the server can offer at all.
+func IsSupported(name string) bool {
+	for _, supported := range Supported {
+		if supported == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Begin marks that the client has started capability negotiation (a `CAP
+// LS` or `CAP REQ` was seen), so WaitNegotiation should block for the
+// matching `CAP END` instead of assuming a legacy client that never
+// negotiates at all.
+func (m *CapabilitiesMap) Begin() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.began = true
+}
+
+// Began reports whether Begin has been called.
+func (m *CapabilitiesMap) Began() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.began
+}
+
+// Request marks name as negotiated for this connection, as a result of a
+// `CAP REQ`. It reports whether name is actually supported, so callers can
+// NAK unsupported requests.
+func (m *CapabilitiesMap) Request(name string) bool {
+	if !IsSupported(name) {
+		return false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.negotiated[name] = true
+	return true
+}
+
+// HasCapability reports whether name has been negotiated for this
+// connection.
+func (m *CapabilitiesMap) HasCapability(name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.negotiated[name]
+}
+
+// EndNegotiation unblocks WaitNegotiation, signalling that the client sent
+// `CAP END` (or never started negotiating in the first place).
+func (m *CapabilitiesMap) EndNegotiation() {
+	m.doneOnce.Do(func() { close(m.done) })
+}
+
+// WaitNegotiation blocks until EndNegotiation has been called.
+func (m *CapabilitiesMap) WaitNegotiation() {
+	<-m.done
+}
+
+// NegotiationEnded reports whether EndNegotiation has already been called,
+// without blocking.
+func (m *CapabilitiesMap) NegotiationEnded() bool {
+	select {
+	case <-m.done:
+		return true
+	default:
+		return false
+	}
+}
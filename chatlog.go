@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// logBaseDir is the root directory chat logs are written under. Layout
+// mirrors soju's logPath scheme: logs/<nick>/<chat-id>/YYYY-MM-DD.log
+const logBaseDir = "logs"
+
+// formatServerTime formats t as an IRCv3 server-time value, as used in the
+// `time` message tag (https://ircv3.net/specs/extensions/server-time).
+func formatServerTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+// sanitizePathComponent makes s safe to use as a single path element under
+// logBaseDir. nickname comes straight from the client's NICK command, so
+// without this a nickname like "../../etc" could be used to write log files
+// outside of logs/; path separators are replaced and leading dots stripped,
+// rather than rejected outright, so callers don't have to handle an error here.
+func sanitizePathComponent(s string) string {
+	s = strings.NewReplacer("/", "_", "\\", "_").Replace(s)
+	s = strings.TrimLeft(s, ".")
+	if s == "" {
+		s = "_"
+	}
+	return s
+}
+
+// chatLogPath returns the path of the log file a message for the given chat,
+// sent at the given time, should be appended to.
+func chatLogPath(nickname, chatID string, t time.Time) string {
+	day := t.UTC().Format("2006-01-02")
+	nickname = sanitizePathComponent(nickname)
+	chatID = sanitizePathComponent(chatID)
+	return filepath.Join(logBaseDir, nickname, chatID, day+".log")
+}
+
+// appendChatLog appends a single line to the per-chat, per-day log file for
+// chatID, creating any missing directories along the way.
+func appendChatLog(nickname, chatID string, t time.Time, line string) error {
+	path := chatLogPath(nickname, chatID, t)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "%s %s\n", formatServerTime(t), line)
+	return err
+}
+
+// chatHistoryEntry is a single logged line, parsed back out of a chat log
+// file for replay through `draft/chathistory`.
+type chatHistoryEntry struct {
+	Time time.Time
+	Line string
+}
+
+// readChatLogDay reads every entry logged for chatID on the UTC day of t.
+func readChatLogDay(nickname, chatID string, t time.Time) ([]chatHistoryEntry, error) {
+	path := chatLogPath(nickname, chatID, t)
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []chatHistoryEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		ts, err := time.Parse("2006-01-02T15:04:05.000Z", parts[0])
+		if err != nil {
+			continue
+		}
+
+		entries = append(entries, chatHistoryEntry{Time: ts, Line: parts[1]})
+	}
+	return entries, scanner.Err()
+}
+
+// chatHistoryBetween returns up to limit entries strictly before before and,
+// unless after is the zero time, at or after after. It searches backwards
+// day by day starting at before's UTC day until enough entries are found,
+// the UTC day of the after bound has been searched, or 365 days have been
+// searched.
+func chatHistoryBetween(nickname, chatID string, after, before time.Time, limit int) ([]chatHistoryEntry, error) {
+	var result []chatHistoryEntry
+	day := before
+
+	for i := 0; i < 365 && len(result) < limit; i++ {
+		if !after.IsZero() && day.UTC().Format("2006-01-02") < after.UTC().Format("2006-01-02") {
+			break
+		}
+
+		entries, err := readChatLogDay(nickname, chatID, day)
+		if err != nil {
+			return nil, err
+		}
+
+		for j := len(entries) - 1; j >= 0; j-- {
+			entry := entries[j]
+			if !entry.Time.Before(before) {
+				continue
+			}
+			if !after.IsZero() && entry.Time.Before(after) {
+				continue
+			}
+
+			result = append([]chatHistoryEntry{entry}, result...)
+			if len(result) >= limit {
+				break
+			}
+		}
+
+		day = day.AddDate(0, 0, -1)
+	}
+
+	if len(result) > limit {
+		result = result[len(result)-limit:]
+	}
+	return result, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func withTempCwd(t *testing.T) {
+	t.Helper()
+
+	dir := t.TempDir()
+	old, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(old) })
+}
+
+func TestAppendAndReadChatLogDay(t *testing.T) {
+	withTempCwd(t)
+
+	day := time.Date(2026, 7, 25, 10, 0, 0, 0, time.UTC)
+
+	if err := appendChatLog("alice", "chat1", day, ":bob PRIVMSG #chat1 :hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err := appendChatLog("alice", "chat1", day.Add(time.Minute), ":bob PRIVMSG #chat1 :world"); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := readChatLogDay("alice", "chat1", day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(entries))
+	}
+	if entries[0].Line != ":bob PRIVMSG #chat1 :hello" {
+		t.Errorf("unexpected first line: %q", entries[0].Line)
+	}
+	if entries[1].Line != ":bob PRIVMSG #chat1 :world" {
+		t.Errorf("unexpected second line: %q", entries[1].Line)
+	}
+}
+
+func TestReadChatLogDayMissingFile(t *testing.T) {
+	withTempCwd(t)
+
+	entries, err := readChatLogDay("alice", "nonexistent", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries != nil {
+		t.Errorf("expected no entries, got %v", entries)
+	}
+}
+
+func TestChatHistoryBetween(t *testing.T) {
+	withTempCwd(t)
+
+	base := time.Date(2026, 7, 25, 10, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		ts := base.Add(time.Duration(i) * time.Minute)
+		line := ":bob PRIVMSG #chat1 :msg"
+		if err := appendChatLog("alice", "chat1", ts, line); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := chatHistoryBetween("alice", "chat1", time.Time{}, base.Add(10*time.Minute), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries (limit), got %d", len(entries))
+	}
+
+	entries, err = chatHistoryBetween("alice", "chat1", base.Add(2*time.Minute), base.Add(10*time.Minute), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 entries at or after the `after` bound, got %d", len(entries))
+	}
+}
+
+func TestFormatServerTime(t *testing.T) {
+	got := formatServerTime(time.Date(2026, 7, 25, 10, 30, 0, 0, time.UTC))
+	want := "2026-07-25T10:30:00.000Z"
+	if got != want {
+		t.Errorf("formatServerTime() = %q, want %q", got, want)
+	}
+}
@@ -3,14 +3,20 @@ package main
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"log"
-	"math"
 	"net"
+	"os"
 	"regexp"
 	"strings"
 	"time"
+	"whapp-irc/auth"
+	"whapp-irc/backend"
+	"whapp-irc/banlist"
 	"whapp-irc/capabilities"
 	"whapp-irc/database"
 	"whapp-irc/whapp"
@@ -21,6 +27,12 @@ import (
 	irc "gopkg.in/sorcix/irc.v2"
 )
 
+// controlNick is the pseudo-user, distinct from any real chat, that admin
+// commands like !ban/!unban/!banlist/!profiles must be addressed to. It
+// matches the sender name whapp-irc uses for its own server-originated
+// lines (numerics, status messages), so it reads naturally as "the bot".
+const controlNick = "whapp-irc"
+
 func logMessage(time time.Time, from, to, message string) {
 	timeStr := time.Format("2006-01-02 15:04:05")
 	log.Printf("(%s) %s->%s: %s", timeStr, from, to, message)
@@ -36,8 +48,13 @@ type Connection struct {
 
 	caps *capabilities.CapabilitiesMap
 
-	bridge *Bridge
-	socket *net.TCPConn
+	bridge  *Bridge
+	backend backend.Backend
+	// needsBridge is true when backend requires the headless-Chrome bridge
+	// (the whapp driver); other drivers, like whatsmeow, must not launch
+	// Chrome at all.
+	needsBridge bool
+	socket      net.Conn
 
 	welcomed  bool
 	welcomeCh chan bool
@@ -45,11 +62,27 @@ type Connection struct {
 	localStorage map[string]string
 
 	timestampMap *TimestampMap
+
+	// authenticated is set once SASL PLAIN or EXTERNAL succeeds. Until
+	// then the connection is still allowed to negotiate capabilities and
+	// register a nickname, but setup() is not started.
+	authenticated bool
+	saslMechanism string
+	peerCert      *x509.Certificate
+
+	banlist *banlist.List
 }
 
 func MakeConnection() (*Connection, error) {
+	be, needsBridge, err := newBackend()
+	if err != nil {
+		return nil, err
+	}
+
 	return &Connection{
-		bridge: MakeBridge(),
+		bridge:      MakeBridge(),
+		backend:     be,
+		needsBridge: needsBridge,
 
 		welcomeCh: make(chan bool),
 
@@ -58,30 +91,69 @@ func MakeConnection() (*Connection, error) {
 	}, nil
 }
 
-func (conn *Connection) BindSocket(socket *net.TCPConn) error {
+// resetRegistrationState clears everything about conn that is specific to
+// one IRC socket rather than to the underlying WhatsApp session, so a
+// ProfileManager profile's Connection can be rebound to a new socket
+// without inheriting the previous socket's already-finished registration
+// and capability negotiation.
+func (conn *Connection) resetRegistrationState() {
+	conn.welcomed = false
+	conn.welcomeCh = make(chan bool)
+	conn.caps = capabilities.MakeCapabilitiesMap()
+	conn.authenticated = false
+	conn.saslMechanism = ""
+	conn.peerCert = nil
+}
+
+func (conn *Connection) BindSocket(socket net.Conn) error {
 	defer socket.Close()
 	defer conn.bridge.Stop()
 
+	// A ProfileManager profile's Connection outlives any one socket: on a
+	// rebind, the WhatsApp-side state (backend, Chats, banlist) is kept,
+	// though the bridge was stopped when the previous socket closed and
+	// welcome() runs setup() again; registration/negotiation state is specific to the
+	// socket that's going away. Reset it here so welcome() and CAP
+	// negotiation run fresh for this socket instead of short-circuiting on
+	// the previous socket's already-closed welcomeCh/caps.
+	conn.resetRegistrationState()
+
 	conn.socket = socket
 
+	if tlsConn, ok := socket.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			return err
+		}
+		if certs := tlsConn.ConnectionState().PeerCertificates; len(certs) > 0 {
+			conn.peerCert = certs[0]
+		}
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// listen for and parse messages.
 	// we want to do this outside the next irc message handle loop, so we can
 	// reply to PINGs but not handle stuff like JOINs yet.
-	ircCh := make(chan *irc.Message)
+	ircCh := make(chan *taggedMessage)
 	go func() {
 		defer close(ircCh)
 
-		decoder := irc.NewDecoder(bufio.NewReader(socket))
+		reader := bufio.NewReader(socket)
 		for {
-			msg, err := decoder.Decode()
+			line, err := reader.ReadString('\n')
 			if err != nil {
 				log.Printf("error while listening for IRC messages: %s\n", err)
 				return
 			}
 
+			tags, rest := parseIRCTags(strings.TrimRight(line, "\r\n"))
+			raw := irc.ParseMessage(rest)
+			if raw == nil {
+				continue
+			}
+			msg := &taggedMessage{Message: raw, Tags: tags}
+
 			if msg.Command == "PING" {
 				str := ":whapp-irc PONG whapp-irc :" + msg.Params[0]
 				if err := conn.writeIRCNow(str); err != nil {
@@ -90,6 +162,39 @@ func (conn *Connection) BindSocket(socket *net.TCPConn) error {
 				continue
 			}
 
+			if msg.Command == "CHATHISTORY" {
+				if err := conn.handleChathistoryCommand(msg.Message); err != nil {
+					log.Printf("error handling CHATHISTORY command: %s\n", err)
+				}
+				continue
+			}
+
+			if msg.Command == "AUTHENTICATE" {
+				if err := conn.handleAuthenticate(msg.Message); err != nil {
+					log.Printf("error handling SASL AUTHENTICATE: %s\n", err)
+				}
+				continue
+			}
+
+			if msg.Command == "TAGMSG" {
+				if err := conn.handleIncomingTagmsg(msg); err != nil {
+					log.Printf("error handling TAGMSG: %s\n", err)
+				}
+				continue
+			}
+
+			if msg.Command == "CAP" {
+				if err := conn.handleCapCommand(msg.Message); err != nil {
+					log.Printf("error handling CAP command: %s\n", err)
+				}
+				// CAP END is also passed on, so a client that authenticated
+				// during negotiation gets welcomed once negotiation ends.
+				if len(msg.Params) > 0 && strings.EqualFold(msg.Params[0], "END") {
+					ircCh <- msg
+				}
+				continue
+			}
+
 			ircCh <- msg
 		}
 	}()
@@ -98,6 +203,19 @@ func (conn *Connection) BindSocket(socket *net.TCPConn) error {
 		if conn.welcomed || conn.nickname == "" {
 			return false, nil
 		}
+		if authStore != nil && !conn.authenticated {
+			// A client that isn't mid-negotiation (never sent `CAP REQ
+			// sasl`, or already ended negotiation without authenticating)
+			// is never going to authenticate on its own; without this it
+			// would otherwise sit here forever after NICK with no
+			// indication of why. A client still negotiating gets left
+			// alone, since it may yet send AUTHENTICATE before CAP END.
+			if !conn.caps.Began() || conn.caps.NegotiationEnded() {
+				conn.writeIRCNow(fmt.Sprintf(":whapp-irc 904 %s :SASL authentication required", conn.nickname))
+				return false, fmt.Errorf("client did not authenticate via SASL")
+			}
+			return false, nil
+		}
 
 		str := fmt.Sprintf(":whapp-irc 001 %s Welcome to whapp-irc, %s.", conn.nickname, conn.nickname)
 		if err := conn.writeIRCNow(str); err != nil {
@@ -108,6 +226,13 @@ func (conn *Connection) BindSocket(socket *net.TCPConn) error {
 			return false, err
 		}
 
+		// A client that never sent `CAP LS`/`CAP REQ` is never going to
+		// send `CAP END` either; without this, conn.caps.WaitNegotiation
+		// below would block such (legacy) clients forever.
+		if !conn.caps.Began() {
+			conn.caps.EndNegotiation()
+		}
+
 		conn.welcomed = true
 
 		err = retry.Do(func() error {
@@ -122,6 +247,20 @@ func (conn *Connection) BindSocket(socket *net.TCPConn) error {
 			return false, err
 		}
 
+		// Re-announce every group chat to this socket. On a first-ever
+		// connect these are all unjoined and this is just the usual
+		// opening JOIN/NAMES burst; on a ProfileManager rebind, setup()
+		// rebuilt conn.Chats from scratch (see convertChat), so this is
+		// what gets the reconnecting client's channel list back in sync
+		// instead of it waiting for a new message to trickle in per chat.
+		for _, c := range conn.Chats {
+			if c.IsGroupChat {
+				if err := conn.joinChat(c); err != nil {
+					log.Printf("error joining chat %s on connect: %s\n", c.Identifier(), err)
+				}
+			}
+		}
+
 		close(conn.welcomeCh)
 		return true, nil
 	}
@@ -148,7 +287,52 @@ func (conn *Connection) BindSocket(socket *net.TCPConn) error {
 					continue
 				}
 
-				if err := conn.handleIRCCommand(msg); err != nil {
+				if msg.Command == "CAP" {
+					if _, err := welcome(); err != nil {
+						conn.status("giving up trying to setup whapp bridge: " + err.Error())
+						return
+					}
+					continue
+				}
+
+				// !ban/!unban/!banlist/!profiles are only recognized when
+				// addressed directly to controlNick, not in every outgoing
+				// PRIVMSG: the banlist is per-connection, not per-chat (see
+				// handleBanCommand), so there was never a reason to inspect
+				// every chat message for these, and doing so meant a user
+				// could not send a literal "!ban ..." to a real contact.
+				if msg.Command == "PRIVMSG" && len(msg.Params) == 2 && strings.EqualFold(msg.Params[0], controlNick) {
+					if command, args, ok := parseBanCommand(msg.Params[1]); ok {
+						if err := conn.handleBanCommand(command, args); err != nil {
+							log.Printf("error handling ban command: %s\n", err)
+						}
+						continue
+					}
+
+					if strings.TrimSpace(msg.Params[1]) == "!profiles" {
+						if err := conn.handleProfilesCommand(); err != nil {
+							log.Printf("error handling !profiles command: %s\n", err)
+						}
+						continue
+					}
+				}
+
+				// Backends other than whapp have no Chat registry yet (see
+				// the needsBridge branch in setup), so handleIRCCommand's
+				// chat.rawChat-based send path can't resolve a target for
+				// them; route outbound messages through conn.backend
+				// directly instead, using the PRIVMSG target as-is for the
+				// backend chat ID (e.g. a raw whatsmeow JID) until chat
+				// sync exists for this backend too. The whapp driver keeps
+				// using handleIRCCommand's existing send path unchanged.
+				if !conn.needsBridge && msg.Command == "PRIVMSG" && len(msg.Params) == 2 && !strings.EqualFold(msg.Params[0], controlNick) {
+					if err := conn.backend.SendMessage(conn.bridge.ctx, backend.ID(msg.Params[0]), msg.Params[1]); err != nil {
+						log.Printf("error sending message via backend: %s\n", err)
+					}
+					continue
+				}
+
+				if err := conn.handleIRCCommand(msg.Message); err != nil {
 					log.Printf("error handling new irc message: %s\n", err)
 
 					if err == io.ErrClosedPipe {
@@ -163,50 +347,25 @@ func (conn *Connection) BindSocket(socket *net.TCPConn) error {
 	<-conn.welcomeCh
 	conn.caps.WaitNegotiation()
 
-	empty := conn.timestampMap.Length() == 0
+	// Older messages are no longer replayed here: clients that need
+	// backfill negotiate draft/chathistory and ask for it explicitly
+	// (see handleChathistoryCommand), which reads from the per-chat log
+	// instead of re-fetching from WhatsApp. We still record each chat's
+	// latest timestamp, since saveDatabaseEntry persists it.
 	for _, c := range conn.Chats {
-		prevTimestamp, found := conn.timestampMap.Get(c.ID.String())
-
-		if empty || !conn.caps.HasCapability("whapp-irc/replay") {
-			conn.timestampMap.Set(c.ID.String(), c.rawChat.Timestamp)
-			go conn.saveDatabaseEntry()
-			continue
-		} else if c.rawChat.Timestamp <= prevTimestamp {
-			continue
-		}
-
-		if !found {
-			// fetch all older messages
-			prevTimestamp = math.MinInt64
-		}
-
-		messages, err := c.rawChat.GetMessagesFromChatTillDate(
-			conn.bridge.ctx,
-			conn.bridge.WI,
-			prevTimestamp,
-		)
-		if err != nil {
-			log.Printf("error while loading earlier messages: %s\n", err.Error())
-			return err
-		}
-
-		for _, msg := range messages {
-			if msg.Timestamp <= prevTimestamp {
-				continue
-			}
-
-			if err := conn.handleWhappMessage(msg); err != nil {
-				log.Printf("error handling older whapp message: %s\n", err.Error())
-				return err
-			}
-		}
+		conn.timestampMap.Set(c.ID.String(), c.rawChat.Timestamp)
 	}
+	go conn.saveDatabaseEntry()
 	conn.status("ready for new messages")
 
 	go func() {
 		defer cancel()
 
-		resCh, errCh := conn.bridge.WI.ListenLoggedIn(conn.bridge.ctx, time.Second)
+		// Goes through conn.backend, not conn.bridge.WI directly: this is
+		// plain login-state bookkeeping (bool/error channels), identical
+		// across every driver, so it belongs on the backend-agnostic path
+		// rather than assuming the whapp/Chrome bridge.
+		resCh, errCh := conn.backend.ListenLoggedIn(conn.bridge.ctx, time.Second)
 
 		for {
 			select {
@@ -229,44 +388,169 @@ func (conn *Connection) BindSocket(socket *net.TCPConn) error {
 		}
 	}()
 
-	go func() {
-		defer cancel()
+	// The message-receive and typing-presence loops below still go through
+	// conn.bridge.WI/chat.rawChat rather than conn.backend: they need real
+	// whapp.Chat/whapp.Message values (sender identity, per-chat presence),
+	// which only the whapp driver populates conn.Chats with (see setup).
+	// Backends without needsBridge return early from setup with no chats to
+	// iterate, so starting these here would either do nothing or panic on
+	// the never-started bridge; skip them entirely instead.
+	if conn.needsBridge {
+		go func() {
+			defer cancel()
+
+			messageCh, errCh := conn.bridge.WI.ListenForMessages(
+				conn.bridge.ctx,
+				500*time.Millisecond,
+			)
+			queue := GetMessageQueue(ctx, messageCh, 50)
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
 
-		messageCh, errCh := conn.bridge.WI.ListenForMessages(
-			conn.bridge.ctx,
-			500*time.Millisecond,
-		)
-		queue := GetMessageQueue(ctx, messageCh, 50)
+				case err := <-errCh:
+					log.Printf("error while listening for whatsapp messages: %s\n", err.Error())
+					return
 
-		for {
-			select {
-			case <-ctx.Done():
-				return
+				case msgFut := <-queue:
+					msgRes := <-msgFut
+					if msgRes.Err == nil && conn.isBanned(msgRes.Message) {
+						logMessage(time.Now(), msgRes.Message.Chat.ID.String(), conn.nickname, "dropped (banned sender)")
+						continue
+					}
+					if msgRes.Err == nil {
+						msgRes.Err = conn.handleWhappMessage(msgRes.Message)
+					}
 
-			case err := <-errCh:
-				log.Printf("error while listening for whatsapp messages: %s\n", err.Error())
-				return
+					if msgRes.Err != nil {
+						log.Printf("error handling new whapp message: %s\n", msgRes.Err)
+						continue
+					}
 
-			case msgFut := <-queue:
-				msgRes := <-msgFut
-				if msgRes.Err == nil {
-					msgRes.Err = conn.handleWhappMessage(msgRes.Message)
+					if chat := conn.GetChatByID(msgRes.Message.Chat.ID); chat != nil {
+						line := formatWhappMessageLine(chat, msgRes.Message)
+						if err := appendChatLog(conn.nickname, chat.ID.String(), time.Now(), line); err != nil {
+							log.Printf("error appending chat log: %s\n", err)
+						}
+					}
 				}
 
-				if msgRes.Err != nil {
-					log.Printf("error handling new whapp message: %s\n", msgRes.Err)
-					continue
-				}
 			}
+		}()
 
-		}
-	}()
+		go func() {
+			defer cancel()
+			conn.pollComposingAndReceipts(ctx)
+		}()
+	}
 
 	<-ctx.Done()
 	log.Printf("connection ended: %s\n", ctx.Err())
 	return nil
 }
 
+// pollComposingAndReceipts periodically checks the composing (typing) state
+// and read-receipt watermark of every joined chat, emitting a TAGMSG for
+// whichever has changed since the last check: `+typing=active|paused|done`
+// (https://ircv3.net/specs/client-tags/typing) for composing state, and
+// `+draft/read=<msgid>` for the newest message WhatsApp reports as read.
+// Gated on message-tags, since TAGMSG only makes sense once a client has
+// negotiated tags.
+//
+// This used to derive `+typing` from GetPresence's online/offline flag,
+// which fired on every contact that was merely online, not actually
+// composing, and could only ever report "active"/"done". rawChat's own
+// composing-state and read-receipt watermark are the real signals for
+// these two features, so this polls those directly instead.
+func (conn *Connection) pollComposingAndReceipts(ctx context.Context) {
+	if !conn.caps.HasCapability("message-tags") {
+		return
+	}
+
+	lastTyping := make(map[whapp.ID]string)
+	lastRead := make(map[whapp.ID]string)
+
+	ticker := time.NewTicker(3 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			for _, c := range conn.Chats {
+				conn.pollChatComposingState(c, lastTyping)
+				conn.pollChatReadReceipt(c, lastRead)
+			}
+		}
+	}
+}
+
+// pollChatComposingState checks c's current composing state and, if it
+// changed since the last check, emits a `+typing` TAGMSG for it.
+func (conn *Connection) pollChatComposingState(c *Chat, lastTyping map[whapp.ID]string) {
+	composing, err := c.rawChat.GetComposingState(conn.bridge.ctx, conn.bridge.WI)
+	if err != nil {
+		return
+	}
+
+	state := "done"
+	switch composing {
+	case "composing":
+		state = "active"
+	case "paused":
+		state = "paused"
+	}
+
+	if lastTyping[c.ID] == state {
+		return
+	}
+	lastTyping[c.ID] = state
+
+	tag := fmt.Sprintf("@+typing=%s :whapp-irc TAGMSG %s", state, c.Identifier())
+	conn.writeIRCNow(tag)
+}
+
+// pollChatReadReceipt checks the newest message WhatsApp reports as read in
+// c and, if it advanced since the last check, emits a `+draft/read` TAGMSG
+// referencing it.
+func (conn *Connection) pollChatReadReceipt(c *Chat, lastRead map[whapp.ID]string) {
+	msgID, err := c.rawChat.LastReadMessageID(conn.bridge.ctx, conn.bridge.WI)
+	if err != nil || msgID == "" || msgID == lastRead[c.ID] {
+		return
+	}
+	lastRead[c.ID] = msgID
+
+	tag := fmt.Sprintf("@+draft/read=%s :whapp-irc TAGMSG %s", msgID, c.Identifier())
+	conn.writeIRCNow(tag)
+}
+
+// handleIncomingTagmsg reacts to a client-sent TAGMSG, currently only the
+// `+typing` client tag, by forwarding the local typing state to WhatsApp.
+func (conn *Connection) handleIncomingTagmsg(msg *taggedMessage) error {
+	if !conn.caps.HasCapability("message-tags") {
+		return nil
+	}
+	if len(msg.Params) == 0 {
+		return nil
+	}
+
+	typing, ok := msg.Tags["+typing"]
+	if !ok {
+		return nil
+	}
+
+	chat := conn.GetChatByIdentifier(msg.Params[0])
+	if chat == nil {
+		return fmt.Errorf("tagmsg: unknown target %s", msg.Params[0])
+	}
+
+	return chat.rawChat.SetTyping(conn.bridge.ctx, conn.bridge.WI, typing == "active")
+}
+
 func (conn *Connection) joinChat(chat *Chat) error {
 	if chat == nil {
 		return fmt.Errorf("chat is nil")
@@ -393,9 +677,19 @@ func (conn *Connection) addChat(rawChat whapp.Chat) (*Chat, error) {
 
 // TODO: check if already setup
 func (conn *Connection) setup() error {
-	if _, err := conn.bridge.Start(); err != nil {
+	// Only the whapp driver needs headless Chrome; other backends (e.g.
+	// whatsmeow) talk to WhatsApp directly and must not pay for it.
+	if conn.needsBridge {
+		if _, err := conn.bridge.Start(); err != nil {
+			return err
+		}
+	}
+
+	banlist, err := banlist.MakeList(userDb, conn.nickname)
+	if err != nil {
 		return err
 	}
+	conn.banlist = banlist
 
 	obj, found, err := userDb.GetItem(conn.nickname)
 	if err != nil {
@@ -408,11 +702,11 @@ func (conn *Connection) setup() error {
 
 		conn.timestampMap.Swap(user.LastReceivedReceipts)
 
-		if _, err := conn.bridge.WI.Open(conn.bridge.ctx); err != nil {
+		if _, err := conn.backend.Open(conn.bridge.ctx); err != nil {
 			return err
 		}
 
-		if err := conn.bridge.WI.SetLocalStorage(
+		if err := conn.backend.SetLocalStorage(
 			conn.bridge.ctx,
 			user.LocalStorage,
 		); err != nil {
@@ -420,13 +714,13 @@ func (conn *Connection) setup() error {
 		}
 	}
 
-	state, err := conn.bridge.WI.Open(conn.bridge.ctx)
+	state, err := conn.backend.Open(conn.bridge.ctx)
 	if err != nil {
 		return err
 	}
 
-	if state == whapp.Loggedout {
-		code, err := conn.bridge.WI.GetLoginCode(conn.bridge.ctx)
+	if state == backend.LoggedOut {
+		code, err := conn.backend.GetLoginCode(conn.bridge.ctx)
 		if err != nil {
 			return fmt.Errorf("Error while retrieving login code: %s", err.Error())
 		}
@@ -451,12 +745,12 @@ func (conn *Connection) setup() error {
 		}
 	}
 
-	if err := conn.bridge.WI.WaitLogin(conn.bridge.ctx); err != nil {
+	if err := conn.backend.WaitLogin(conn.bridge.ctx); err != nil {
 		return err
 	}
 	conn.status("logged in")
 
-	conn.localStorage, err = conn.bridge.WI.GetLocalStorage(conn.bridge.ctx)
+	conn.localStorage, err = conn.backend.GetLocalStorage(conn.bridge.ctx)
 	if err != nil {
 		log.Printf("error while getting local storage: %s\n", err.Error())
 	} else {
@@ -465,11 +759,26 @@ func (conn *Connection) setup() error {
 		}
 	}
 
+	if !conn.needsBridge {
+		// Chat.rawChat needs a concrete whapp.Chat (participants, presence
+		// and history all hang off its methods), which the backend-agnostic
+		// backend.Chat can't supply, so only the whapp driver can populate
+		// conn.Chats today. Say so plainly instead of quietly reusing the
+		// (unstarted) Chrome bridge, which would just fail.
+		return conn.status("connected via " + os.Getenv(backendEnvVar) + ", but chat/participant sync for this backend isn't implemented yet")
+	}
+
 	conn.me, err = conn.bridge.WI.GetMe(conn.bridge.ctx)
 	if err != nil {
 		return err
 	}
 
+	// GetAllChats still goes through bridge.WI rather than conn.backend:
+	// Chat.rawChat needs a concrete whapp.Chat (participants, presence and
+	// history all hang off its methods), which the backend-agnostic
+	// backend.Chat can't provide. Only the whapp driver constructs real
+	// Chats today; a whatsmeow-backed Connection returns above with no
+	// Chats at all.
 	chats, err := conn.bridge.WI.GetAllChats(conn.bridge.ctx)
 	if err != nil {
 		return err
@@ -483,15 +792,243 @@ func (conn *Connection) setup() error {
 	return nil
 }
 
-func (conn *Connection) getPresenceByUserID(userID whapp.ID) (presence whapp.Presence, found bool, err error) {
-	for _, c := range conn.Chats {
-		if c.ID == userID {
-			presence, err := c.rawChat.GetPresence(conn.bridge.ctx, conn.bridge.WI)
-			return presence, true, err
+// isBanned reports whether msg's sender is on conn's banlist, suppressing
+// it before it ever reaches handleWhappMessage.
+func (conn *Connection) isBanned(msg whapp.Message) bool {
+	if conn.banlist == nil {
+		return false
+	}
+
+	sender := msg.Sender
+	return conn.banlist.Matches(sender.Contact.ID.String(), sender.Contact.Number, sender.SafeName())
+}
+
+// handleProfilesCommand implements the `!profiles` admin command, listing
+// the nicknames currently hosted by this process's ProfileManager.
+func (conn *Connection) handleProfilesCommand() error {
+	if activeProfileManager == nil {
+		return conn.status("this process is not running in multi-user daemon mode")
+	}
+
+	profiles := activeProfileManager.Profiles()
+	if len(profiles) == 0 {
+		return conn.status("no active profiles")
+	}
+	return conn.status("active profiles: " + strings.Join(profiles, ", "))
+}
+
+// parseBanCommand recognizes `!ban`, `!unban` and `!banlist` in a PRIVMSG
+// body, splitting off the command name and its arguments.
+func parseBanCommand(body string) (command string, args []string, ok bool) {
+	fields := strings.Fields(body)
+	if len(fields) == 0 || !strings.HasPrefix(fields[0], "!") {
+		return "", nil, false
+	}
+
+	name := strings.TrimPrefix(fields[0], "!")
+	switch name {
+	case "ban", "unban", "banlist":
+		return name, fields[1:], true
+	default:
+		return "", nil, false
+	}
+}
+
+// handleBanCommand implements `!ban`, `!unban` and `!banlist`, the
+// controlNick commands for managing the (connection-wide, not per-chat)
+// banlist.
+func (conn *Connection) handleBanCommand(command string, args []string) error {
+	if conn.banlist == nil {
+		return fmt.Errorf("banlist not initialized yet")
+	}
+
+	switch command {
+	case "ban":
+		if len(args) != 1 {
+			return conn.status("usage: !ban <id|number|/regex/>")
+		}
+		if err := conn.banlist.Add(args[0]); err != nil {
+			return conn.status("error adding ban: " + err.Error())
+		}
+		return conn.status("banned " + args[0])
+
+	case "unban":
+		if len(args) != 1 {
+			return conn.status("usage: !unban <id|number|/regex/>")
+		}
+		if err := conn.banlist.Remove(args[0]); err != nil {
+			return conn.status("error removing ban: " + err.Error())
+		}
+		return conn.status("unbanned " + args[0])
+
+	case "banlist":
+		entries := conn.banlist.Entries()
+		if len(entries) == 0 {
+			return conn.status("banlist is empty")
+		}
+		for _, e := range entries {
+			if err := conn.status(fmt.Sprintf("%s: %s", e.Kind, e.Value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown ban command: %s", command)
+}
+
+// handleAuthenticate implements the server side of SASL PLAIN and SASL
+// EXTERNAL, per IRCv3's sasl-3.2. When `authStore` is not configured it
+// replies with 904; callers only reach here once "AUTHENTICATE" is seen on
+// the wire.
+func (conn *Connection) handleAuthenticate(msg *irc.Message) error {
+	if authStore == nil {
+		return conn.writeIRCNow(":whapp-irc 904 * :SASL authentication is not enabled")
+	}
+	if !conn.caps.HasCapability("sasl") {
+		return conn.writeIRCNow(":whapp-irc 904 * :You must request the sasl capability before authenticating")
+	}
+
+	if conn.saslMechanism == "" {
+		if len(msg.Params) == 0 {
+			return conn.writeIRCNow(":whapp-irc 904 * :Missing SASL mechanism")
 		}
+
+		mechanism := strings.ToUpper(msg.Params[0])
+		switch mechanism {
+		case "PLAIN", "EXTERNAL":
+			conn.saslMechanism = mechanism
+			return conn.writeIRCNow("AUTHENTICATE +")
+		default:
+			return conn.writeIRCNow(":whapp-irc 908 * PLAIN,EXTERNAL :are available SASL mechanisms")
+		}
+	}
+
+	mechanism := conn.saslMechanism
+	conn.saslMechanism = ""
+
+	if mechanism == "EXTERNAL" {
+		if conn.peerCert == nil {
+			return conn.writeIRCNow(":whapp-irc 904 * :No client certificate presented")
+		}
+		if conn.nickname == "" {
+			return conn.writeIRCNow(":whapp-irc 904 * :Set a nickname before AUTHENTICATE EXTERNAL")
+		}
+
+		ok, err := authStore.CheckCertificate(conn.nickname, conn.peerCert)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return conn.writeIRCNow(":whapp-irc 904 * :Certificate not recognized")
+		}
+
+		conn.authenticated = true
+		return conn.writeIRCNow(fmt.Sprintf(":whapp-irc 903 %s :SASL authentication successful", conn.nickname))
+	}
+
+	if len(msg.Params) == 0 || msg.Params[0] == "+" {
+		return conn.writeIRCNow(":whapp-irc 904 * :Missing SASL response")
+	}
+
+	payload, err := base64.StdEncoding.DecodeString(msg.Params[0])
+	if err != nil {
+		return conn.writeIRCNow(":whapp-irc 904 * :Invalid SASL response")
+	}
+
+	parts := strings.SplitN(string(payload), "\x00", 3)
+	if len(parts) != 3 {
+		return conn.writeIRCNow(":whapp-irc 904 * :Invalid SASL PLAIN payload")
+	}
+	authcid, password := parts[1], parts[2]
+
+	ok, err := authStore.CheckPassword(authcid, password)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return conn.writeIRCNow(":whapp-irc 904 * :Invalid credentials")
+	}
+
+	conn.nickname = authcid
+	conn.authenticated = true
+	return conn.writeIRCNow(fmt.Sprintf(":whapp-irc 903 %s :SASL authentication successful", conn.nickname))
+}
+
+// handleChathistoryCommand implements the `draft/chathistory` subcommands
+// BEFORE, LATEST and BETWEEN, replying with a BATCH of tagged PRIVMSGs
+// sourced from the on-disk per-chat log rather than WhatsApp itself.
+func (conn *Connection) handleChathistoryCommand(msg *irc.Message) error {
+	if !conn.caps.HasCapability("draft/chathistory") {
+		return fmt.Errorf("chathistory: capability not negotiated")
+	}
+	if len(msg.Params) < 2 {
+		return fmt.Errorf("chathistory: not enough parameters")
 	}
 
-	return whapp.Presence{}, false, nil
+	subcommand := strings.ToUpper(msg.Params[0])
+	target := msg.Params[1]
+
+	limit := 50
+	after := time.Time{}
+	before := time.Now()
+
+	switch subcommand {
+	case "LATEST":
+		// use zero-value defaults: latest `limit` messages up to now.
+	case "BEFORE":
+		if len(msg.Params) < 3 {
+			return fmt.Errorf("chathistory BEFORE: missing timestamp")
+		}
+		ts, err := parseChathistoryTimestamp(msg.Params[2])
+		if err != nil {
+			return err
+		}
+		before = ts
+	case "BETWEEN":
+		if len(msg.Params) < 4 {
+			return fmt.Errorf("chathistory BETWEEN: missing timestamps")
+		}
+		startTs, err := parseChathistoryTimestamp(msg.Params[2])
+		if err != nil {
+			return err
+		}
+		endTs, err := parseChathistoryTimestamp(msg.Params[3])
+		if err != nil {
+			return err
+		}
+		after, before = startTs, endTs
+	default:
+		return fmt.Errorf("chathistory: unsupported subcommand %s", subcommand)
+	}
+
+	chat := conn.GetChatByIdentifier(target)
+	if chat == nil {
+		return fmt.Errorf("chathistory: unknown target %s", target)
+	}
+
+	entries, err := chatHistoryBetween(conn.nickname, chat.ID.String(), after, before, limit)
+	if err != nil {
+		return err
+	}
+
+	batchID := strTimestamp()
+	if err := conn.writeIRCNow(fmt.Sprintf(":whapp-irc BATCH +%s chathistory %s", batchID, target)); err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		tagged := fmt.Sprintf("@batch=%s;time=%s %s", batchID, formatServerTime(entry.Time), entry.Line)
+		if err := conn.writeIRCNow(tagged); err != nil {
+			return err
+		}
+	}
+	return conn.writeIRCNow(fmt.Sprintf(":whapp-irc BATCH -%s", batchID))
+}
+
+// parseChathistoryTimestamp parses a `timestamp=...` chathistory parameter.
+func parseChathistoryTimestamp(param string) (time.Time, error) {
+	param = strings.TrimPrefix(param, "timestamp=")
+	return time.Parse("2006-01-02T15:04:05.000Z", param)
 }
 
 func (conn *Connection) saveDatabaseEntry() error {
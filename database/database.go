@@ -0,0 +1,77 @@
+// Package database is a small JSON-file-backed key/value store used to
+// persist per-user state (WhatsApp sessions, SASL credentials, banlists)
+// between runs of whapp-irc.
+package database
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// User is the per-nickname record saved after a successful WhatsApp login,
+// so a reconnect can restore the WhatsApp web session and pick up
+// unacknowledged receipts where it left off.
+type User struct {
+	LocalStorage         map[string]string `mapstructure:"local_storage" json:"local_storage"`
+	LastReceivedReceipts map[string]int64  `mapstructure:"last_received_receipts" json:"last_received_receipts"`
+}
+
+// Database is a flat, file-backed map of string keys to arbitrary JSON
+// values, guarded by a mutex so concurrent connections can share one file.
+type Database struct {
+	mu   sync.Mutex
+	path string
+	data map[string]interface{}
+}
+
+// MakeDatabase opens (or creates) the database file at path.
+func MakeDatabase(path string) (*Database, error) {
+	db := &Database{path: path, data: make(map[string]interface{})}
+
+	bytes, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return db, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if len(bytes) == 0 {
+		return db, nil
+	}
+	if err := json.Unmarshal(bytes, &db.data); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// GetItem looks up key, reporting whether it was found.
+func (db *Database) GetItem(key string) (interface{}, bool, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	value, found := db.data[key]
+	return value, found, nil
+}
+
+// SaveItem stores value under key and persists the database to disk.
+//
+// value is kept in memory as-is (every caller later reads it back with
+// mapstructure.Decode, e.g. auth.Store.get, which handles a struct source
+// just as well as the map[string]interface{} a restart would reload from
+// disk), and is JSON-encoded only for the on-disk copy.
+func (db *Database) SaveItem(key string, value interface{}) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.data[key] = value
+	return db.save()
+}
+
+func (db *Database) save() error {
+	bytes, err := json.Marshal(db.data)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(db.path, bytes, 0600)
+}
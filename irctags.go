@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+
+	irc "gopkg.in/sorcix/irc.v2"
+)
+
+// taggedMessage pairs an *irc.Message with the IRCv3 message tags that
+// preceded it on the wire. gopkg.in/sorcix/irc.v2 predates IRCv3 and has no
+// concept of tags, so we strip and parse them ourselves before handing the
+// rest of the line to it.
+type taggedMessage struct {
+	*irc.Message
+	Tags map[string]string
+}
+
+// parseIRCTags splits a leading `@key=value;key=value ` tag prefix (if any)
+// off of line, per https://ircv3.net/specs/extensions/message-tags, and
+// returns the parsed tags plus the remainder of the line.
+func parseIRCTags(line string) (map[string]string, string) {
+	if !strings.HasPrefix(line, "@") {
+		return nil, line
+	}
+
+	end := strings.IndexByte(line, ' ')
+	if end < 0 {
+		return nil, line
+	}
+
+	tags := make(map[string]string)
+	for _, pair := range strings.Split(line[1:end], ";") {
+		if pair == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) == 2 {
+			tags[kv[0]] = unescapeTagValue(kv[1])
+		} else {
+			tags[kv[0]] = ""
+		}
+	}
+
+	rest := strings.TrimLeft(line[end+1:], " ")
+	return tags, rest
+}
+
+var tagEscapes = strings.NewReplacer(
+	`\:`, ";",
+	`\s`, " ",
+	`\\`, `\`,
+	`\r`, "\r",
+	`\n`, "\n",
+)
+
+func unescapeTagValue(value string) string {
+	return tagEscapes.Replace(value)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/tls"
+	"flag"
+	"log"
+	"net"
+	"time"
+
+	"whapp-irc/auth"
+	"whapp-irc/database"
+)
+
+// Environment variables read at startup, alongside backendEnvVar in
+// backend_select.go.
+const (
+	listenAddrEnvVar    = "WHAPP_IRC_LISTEN"
+	tlsListenAddrEnvVar = "WHAPP_IRC_TLS_LISTEN"
+	tlsCertEnvVar       = "WHAPP_IRC_TLS_CERT"
+	tlsKeyEnvVar        = "WHAPP_IRC_TLS_KEY"
+)
+
+func main() {
+	dbPath := flag.String("db", "db.json", "path to the whapp-irc database file")
+	listenAddr := flag.String("listen", ":6667", "address to listen for plain-text IRC connections on")
+	profileIdleTimeout := flag.Duration("profile-idle-timeout", 30*time.Minute, "how long an idle profile is kept alive for before eviction")
+	maxProfiles := flag.Int("max-profiles", defaultMaxProfiles, "maximum number of concurrent nickname profiles")
+	flag.Parse()
+
+	if v := getenvOr(listenAddrEnvVar, ""); v != "" {
+		*listenAddr = v
+	}
+
+	db, err := database.MakeDatabase(*dbPath)
+	if err != nil {
+		log.Fatalf("error opening database: %s\n", err)
+	}
+	userDb = db
+
+	if getenvOr(tlsCertEnvVar, "") != "" {
+		authStore = auth.MakeStore(db)
+	}
+
+	pm := MakeProfileManager(*maxProfiles, *profileIdleTimeout)
+
+	if certFile, keyFile := getenvOr(tlsCertEnvVar, ""), getenvOr(tlsKeyEnvVar, ""); certFile != "" && keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			log.Fatalf("error loading TLS certificate: %s\n", err)
+		}
+
+		addr := getenvOr(tlsListenAddrEnvVar, ":6697")
+		ln, err := listenTLS(addr, cert)
+		if err != nil {
+			log.Fatalf("error listening for TLS connections on %s: %s\n", addr, err)
+		}
+
+		log.Printf("listening for TLS IRC connections on %s\n", addr)
+		go func() {
+			log.Fatal(RunServer(ln, pm))
+		}()
+	}
+
+	ln, err := net.Listen("tcp", *listenAddr)
+	if err != nil {
+		log.Fatalf("error listening for connections on %s: %s\n", *listenAddr, err)
+	}
+
+	log.Printf("listening for IRC connections on %s\n", *listenAddr)
+	log.Fatal(RunServer(ln, pm))
+}
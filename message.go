@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"whapp-irc/whapp"
+)
+
+// handleWhappMessage converts an incoming WhatsApp message into the
+// corresponding IRC PRIVMSG (or NOTICE), tagged with IRCv3 server-time
+// (https://ircv3.net/specs/extensions/server-time) so clients that
+// negotiated it can order and backfill messages correctly.
+func (conn *Connection) handleWhappMessage(msg whapp.Message) error {
+	chat := conn.GetChatByID(msg.Chat.ID)
+	if chat == nil {
+		return fmt.Errorf("handleWhappMessage: unknown chat %s", msg.Chat.ID)
+	}
+
+	if chat.IsGroupChat {
+		if err := conn.joinChat(chat); err != nil {
+			return err
+		}
+	}
+
+	line := formatWhappMessageLine(chat, msg)
+	if err := conn.writeTaggedMessage(msg.Timestamp, line); err != nil {
+		return err
+	}
+
+	chat.MessageIDs = append(chat.MessageIDs, msg.ID)
+	return nil
+}
+
+// formatWhappMessageLine renders msg as the raw IRC line it should appear
+// as on the wire, without any tags. Shared between live delivery
+// (handleWhappMessage) and the per-chat log (chatlog.go), so a replayed
+// chathistory line is identical to the one originally sent.
+func formatWhappMessageLine(chat *Chat, msg whapp.Message) string {
+	command := "PRIVMSG"
+	if msg.IsNotice {
+		command = "NOTICE"
+	}
+
+	return fmt.Sprintf(":%s %s %s :%s", msg.Sender.SafeName(), command, chat.Identifier(), msg.Body)
+}
+
+// writeTaggedMessage writes str to the client, prefixed with an IRCv3
+// server-time tag derived from timestamp when the client negotiated
+// server-time.
+func (conn *Connection) writeTaggedMessage(timestamp int64, str string) error {
+	if conn.caps.HasCapability("server-time") {
+		str = fmt.Sprintf("@time=%s %s", formatServerTime(time.Unix(timestamp, 0)), str)
+	}
+	return conn.writeIRCNow(str)
+}
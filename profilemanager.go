@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+// defaultMaxProfiles is used when ProfileManager is constructed without an
+// explicit cap.
+const defaultMaxProfiles = 32
+
+// activeProfileManager is the ProfileManager the running process routes
+// accepted sockets through, set by RunServer. It stays nil when whapp-irc
+// is run in its original single-profile-per-process mode, in which case
+// the `!profiles` command has nothing to report.
+var activeProfileManager *ProfileManager
+
+// ProfileManager owns one *Connection per nickname, so a single whapp-irc
+// process can host many users the way soju or znc host many IRC users.
+// Reconnecting with a nickname that already has an idle profile reuses its
+// existing Connection rather than creating a new one. That Connection's
+// bridge is stopped when its socket closes, so the new socket runs setup()
+// again, restoring the session from the local storage saved in userDb; a
+// live WhatsApp session is not kept open between sockets. A nickname with
+// an already-live socket is refused rather
+// than reused, since Connection's registration state and conn.Chats are
+// not safe for two sockets to drive concurrently.
+type ProfileManager struct {
+	maxProfiles int
+	idleTimeout time.Duration
+
+	mu       sync.Mutex
+	profiles map[string]*profile
+}
+
+type profile struct {
+	conn *Connection
+
+	mu         sync.Mutex
+	lastActive time.Time
+	sockets    int
+}
+
+// MakeProfileManager returns a ProfileManager that evicts profiles idle for
+// longer than idleTimeout and caps itself at maxProfiles concurrent
+// profiles. A non-positive maxProfiles falls back to defaultMaxProfiles.
+func MakeProfileManager(maxProfiles int, idleTimeout time.Duration) *ProfileManager {
+	if maxProfiles <= 0 {
+		maxProfiles = defaultMaxProfiles
+	}
+
+	pm := &ProfileManager{
+		maxProfiles: maxProfiles,
+		idleTimeout: idleTimeout,
+		profiles:    make(map[string]*profile),
+	}
+
+	if idleTimeout > 0 {
+		go pm.evictLoop()
+	}
+
+	return pm
+}
+
+// HandleSocket routes socket to the profile for nickname, creating that
+// profile (and its Connection) on first use. It blocks until the socket's
+// BindSocket call returns, same as calling BindSocket directly would.
+// Rebinding an idle profile is fine (Connection.resetRegistrationState
+// gives the new socket a fresh registration/CAP handshake); rebinding a
+// profile that already has a live socket is refused instead, since two
+// sockets driving one Connection concurrently would race on conn.Chats
+// and friends.
+func (pm *ProfileManager) HandleSocket(nickname string, socket net.Conn) error {
+	p, err := pm.getOrCreate(nickname)
+	if err != nil {
+		socket.Close()
+		return err
+	}
+
+	p.mu.Lock()
+	if p.sockets > 0 {
+		p.mu.Unlock()
+		socket.Close()
+		return fmt.Errorf("profile manager: %s already has an active connection", nickname)
+	}
+	p.sockets++
+	p.lastActive = time.Now()
+	p.mu.Unlock()
+
+	defer func() {
+		p.mu.Lock()
+		p.sockets--
+		p.lastActive = time.Now()
+		p.mu.Unlock()
+	}()
+
+	return p.conn.BindSocket(socket)
+}
+
+func (pm *ProfileManager) getOrCreate(nickname string) (*profile, error) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	if p, found := pm.profiles[nickname]; found {
+		return p, nil
+	}
+
+	if len(pm.profiles) >= pm.maxProfiles {
+		return nil, fmt.Errorf("profile manager: at capacity (%d profiles)", pm.maxProfiles)
+	}
+
+	conn, err := MakeConnection()
+	if err != nil {
+		return nil, err
+	}
+	conn.nickname = nickname
+
+	p := &profile{conn: conn, lastActive: time.Now()}
+	pm.profiles[nickname] = p
+	return p, nil
+}
+
+// Profiles lists the currently known nicknames, for the `!profiles` admin
+// command.
+func (pm *ProfileManager) Profiles() []string {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	nicks := make([]string, 0, len(pm.profiles))
+	for nick := range pm.profiles {
+		nicks = append(nicks, nick)
+	}
+	return nicks
+}
+
+// evictLoop periodically stops and drops profiles that have had no open
+// socket for longer than idleTimeout.
+func (pm *ProfileManager) evictLoop() {
+	ticker := time.NewTicker(pm.idleTimeout / 2)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		pm.evictIdle()
+	}
+}
+
+func (pm *ProfileManager) evictIdle() {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	for nick, p := range pm.profiles {
+		p.mu.Lock()
+		idle := p.sockets == 0 && time.Since(p.lastActive) > pm.idleTimeout
+		p.mu.Unlock()
+
+		if !idle {
+			continue
+		}
+
+		log.Printf("evicting idle profile %s\n", nick)
+		p.conn.bridge.Stop()
+		delete(pm.profiles, nick)
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net"
+	"strings"
+
+	irc "gopkg.in/sorcix/irc.v2"
+)
+
+// RunServer accepts connections from ln and routes each one, by nickname,
+// through pm, so many users can share this one process (see
+// profilemanager.go). It sets activeProfileManager so the `!profiles`
+// command can report on it, and blocks until ln.Accept fails.
+func RunServer(ln net.Listener, pm *ProfileManager) error {
+	activeProfileManager = pm
+
+	for {
+		socket, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+
+		go func() {
+			if err := routeToProfile(socket, pm); err != nil {
+				log.Printf("error routing connection to a profile: %s\n", err)
+			}
+		}()
+	}
+}
+
+// routeToProfile sniffs the nickname off the client's first NICK line,
+// then hands the socket to pm for that nickname, replaying the already-read
+// bytes so the profile's BindSocket sees the NICK line as usual.
+func routeToProfile(socket net.Conn, pm *ProfileManager) error {
+	reader := bufio.NewReader(socket)
+
+	var nickname string
+	var consumed []string
+	for nickname == "" {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			socket.Close()
+			return err
+		}
+		consumed = append(consumed, line)
+
+		msg := irc.ParseMessage(strings.TrimRight(line, "\r\n"))
+		if msg != nil && msg.Command == "NICK" && len(msg.Params) > 0 {
+			nickname = msg.Params[0]
+		}
+	}
+
+	wrapped := &peekedConn{
+		Conn: socket,
+		r:    io.MultiReader(strings.NewReader(strings.Join(consumed, "")), reader),
+	}
+	return pm.HandleSocket(nickname, wrapped)
+}
+
+// peekedConn is a net.Conn whose Read replays bytes already consumed while
+// sniffing for a nickname before falling back to the rest of the
+// connection.
+type peekedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *peekedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
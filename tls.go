@@ -0,0 +1,30 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+
+	"whapp-irc/auth"
+	"whapp-irc/database"
+)
+
+// authStore holds SASL credentials, and userDb the per-nickname WhatsApp
+// session/banlist state; both are set up in main() and stay nil when
+// whapp-irc is used as a library without its own main(), e.g. in tests.
+// authStore additionally stays nil whenever SASL is not configured, in
+// which case connections skip authentication entirely.
+var authStore *auth.Store
+var userDb *database.Database
+
+// listenTLS is the TLS-enabled counterpart of a plain net.Listener accept
+// loop: it requests, but does not require, a client certificate (and does
+// not itself verify one against a CA, since SASL EXTERNAL does the pinning
+// check against authStore), so BindSocket can read it back off the
+// connection when the client sends one.
+func listenTLS(addr string, cert tls.Certificate) (net.Listener, error) {
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequestClientCert,
+	}
+	return tls.Listen("tcp", addr, config)
+}
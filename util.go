@@ -57,6 +57,15 @@ func ircSafeString(str string) string {
 	return unsafeRegex.ReplaceAllLiteralString(str, "")
 }
 
+// getenvOr returns the value of the environment variable key, or fallback
+// if it is unset or empty.
+func getenvOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
+
 func onInterrupt(fn func()) {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)